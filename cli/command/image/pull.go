@@ -1,26 +1,39 @@
 package image
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
 	"strings"
+	"sync"
 
 	"github.com/docker/cli/cli"
 	"github.com/docker/cli/cli/command"
+	registryclient "github.com/docker/cli/cli/registry/client"
+	"github.com/docker/cli/cli/streams"
 	"github.com/docker/cli/cli/trust"
+	"github.com/docker/distribution"
 	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
 // PullOptions defines what and how to pull
 type PullOptions struct {
-	remote    string
-	all       bool
-	platform  string
-	untrusted bool
-	source    	bool
-	source_only bool
+	remote           string
+	all              bool
+	platforms        []string
+	untrusted        bool
+	source           bool
+	source_only      bool
+	sourceAnnotation string
+	outputDigests    string
 }
 
 // NewPullCommand creates a new `docker pull` command
@@ -33,6 +46,14 @@ func NewPullCommand(dockerCli command.Cli) *cobra.Command {
 		Args:  cli.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			opts.remote = args[0]
+			if len(opts.platforms) == 0 {
+				// Preserve the DOCKER_DEFAULT_PLATFORM default that
+				// command.AddPlatformFlag used to wire in for the single
+				// --platform flag this repeatable one replaces.
+				if def := os.Getenv("DOCKER_DEFAULT_PLATFORM"); def != "" {
+					opts.platforms = []string{def}
+				}
+			}
 			return RunPull(dockerCli, opts)
 		},
 	}
@@ -42,8 +63,10 @@ func NewPullCommand(dockerCli command.Cli) *cobra.Command {
 	flags.BoolVarP(&opts.all, "all-tags", "a", false, "Download all tagged images in the repository")
 	flags.BoolVar(&opts.source, "source", false, "Download the source container in addition to the image")
 	flags.BoolVar(&opts.source_only, "source-only", false, "Download only the source container for the image")
+	flags.StringVar(&opts.sourceAnnotation, "source-annotation", "", "Annotation (KEY=VALUE) identifying the source container descriptor, instead of "+DefaultSourceAnnotation)
 
-	command.AddPlatformFlag(flags, &opts.platform)
+	flags.StringSliceVar(&opts.platforms, "platform", nil, "Pull content for one or more specific platforms (repeat the flag or pass a comma-separated list, e.g. linux/amd64,linux/arm64)")
+	flags.StringVar(&opts.outputDigests, "output-digests", "", "Write the fully-qualified digest(s) resolved by this pull to FILE")
 	command.AddTrustVerificationFlags(flags, &opts.untrusted, dockerCli.ContentTrustEnabled())
 
 	return cmd
@@ -59,6 +82,8 @@ func RunPull(cli command.Cli, opts PullOptions) error {
 		return errors.New("tag can't be used with --all-tags/-a")
 	case opts.all && (opts.source || opts.source_only):
 		return errors.New("can't download source with --all-tags/-a")
+	case opts.all && len(opts.platforms) > 1:
+		return errors.New("can't use --all-tags/-a with multiple platforms")
 	case !opts.all && reference.IsNameOnly(distributionRef):
 		distributionRef = reference.TagNameOnly(distributionRef)
 		if tagged, ok := distributionRef.(reference.Tagged); ok {
@@ -66,19 +91,6 @@ func RunPull(cli command.Cli, opts PullOptions) error {
 		}
 	}
 
-	var pullSource bool
-
-	// If we want to pull just the container source, we only need to set the platform
-	// If we want to pull both container and source, we need to pass a flag along
-	if opts.source_only {
-		opts.platform = "linux/source"
-		pullSource = false
-	} else if opts.source {
-		pullSource = true
-	} else {
-		pullSource = false
-	}
-
 	ctx := context.Background()
 	imgRefAndAuth, err := trust.GetImageReferencesAndAuth(ctx, nil, AuthResolver(cli), distributionRef.String())
 	if err != nil {
@@ -87,10 +99,151 @@ func RunPull(cli command.Cli, opts PullOptions) error {
 
 	// Check if reference has a digest
 	_, isCanonical := distributionRef.(reference.Canonical)
+
+	var pullSource bool
+	var sourceDesc SourceDescriptor
+	// If we want to pull both container and source, we pull the requested
+	// platforms plus the source, so a single invocation can fetch e.g.
+	// "image + source + arm64".
+	platforms := append([]string{}, opts.platforms...)
+	if opts.source || opts.source_only {
+		sourceDesc, err = ResolveSourceDescriptor(ctx, defaultManifestLister{cli: cli}, imgRefAndAuth, opts.sourceAnnotation)
+		if err != nil {
+			return err
+		}
+	}
+	switch {
+	case opts.source_only:
+		// --source-only means just the source container: any --platform the
+		// user also passed doesn't add a regular image pull alongside it.
+		if sourceDesc.Digest == "" {
+			// No registry annotation found: fall back to the legacy synthetic
+			// platform used before source containers were discoverable this way.
+			platforms = []string{"linux/source"}
+		} else {
+			platforms = nil
+		}
+	case opts.source && sourceDesc.Digest == "":
+		// No registry annotation found: fall back to asking the engine to
+		// pull the source alongside the image platform, as before
+		// annotation-based discovery existed. Its digest can't be recorded
+		// separately from the image's in --output-digests in that case.
+		pullSource = true
+	}
+
+	if len(platforms) == 0 && !opts.source_only {
+		// No platform was requested: let the daemon/registry pick the default.
+		platforms = []string{""}
+	}
+
+	jobs := make([]func(command.Cli) error, 0, len(platforms)+1)
+	for i, platform := range platforms {
+		platform := platform
+		// pullSource tells the engine to fetch the source container
+		// alongside the image; only the first job needs to ask for that, or
+		// every platform job would pull the (platform-independent) source
+		// container again.
+		pullSourceForJob := pullSource && i == 0
+		jobs = append(jobs, func(jobCli command.Cli) error {
+			return pullOnePlatform(ctx, jobCli, imgRefAndAuth, opts, isCanonical, platform, pullSourceForJob)
+		})
+	}
+	if sourceDesc.Digest != "" {
+		jobs = append(jobs, func(jobCli command.Cli) error {
+			return pullSourceDescriptor(ctx, jobCli, distributionRef, sourceDesc, opts)
+		})
+	}
+
+	if len(jobs) == 1 {
+		if err := jobs[0](cli); err != nil {
+			return err
+		}
+		return writeDigestLockfile(ctx, cli, distributionRef, opts, platforms, sourceDesc)
+	}
+
+	// Fan out one pull per job, so a slow or failing platform/source pull
+	// doesn't block the others. Each job gets its own buffering Cli: the
+	// pull progress renderer assumes exclusive ownership of the terminal
+	// (cursor-position escapes for the progress bars), so writing several
+	// jobs' output straight to cli.Out()/cli.Err() concurrently would
+	// interleave and corrupt it. Buffers are flushed in job order once every
+	// job has finished, after which failures are aggregated.
+	buffers := make([]*bufferedCli, len(jobs))
+	errs := make([]error, len(jobs))
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		i, job := i, job
+		buf := newBufferedCli(cli)
+		buffers[i] = buf
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = job(buf)
+		}()
+	}
+	wg.Wait()
+
+	var failures []string
+	for i, buf := range buffers {
+		buf.flushTo(cli)
+		if errs[i] != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", jobLabel(platforms, sourceDesc, i), errs[i]))
+		}
+	}
+
+	if len(failures) > 0 {
+		return errors.New("failed to pull one or more platforms:\n" + strings.Join(failures, "\n"))
+	}
+	return writeDigestLockfile(ctx, cli, distributionRef, opts, platforms, sourceDesc)
+}
+
+// bufferedCli wraps a command.Cli so a concurrent pull job's progress output
+// lands in memory instead of racing other jobs for the real terminal.
+type bufferedCli struct {
+	command.Cli
+	outBuf, errBuf bytes.Buffer
+	out            *streams.Out
+}
+
+func newBufferedCli(base command.Cli) *bufferedCli {
+	b := &bufferedCli{Cli: base}
+	b.out = streams.NewOut(&b.outBuf)
+	return b
+}
+
+func (b *bufferedCli) Out() *streams.Out { return b.out }
+func (b *bufferedCli) Err() io.Writer    { return &b.errBuf }
+
+// flushTo copies this job's buffered output to base's streams, in the order
+// the job ran, once it and every other concurrent job has finished.
+func (b *bufferedCli) flushTo(base command.Cli) {
+	_, _ = io.Copy(base.Out(), &b.outBuf)
+	_, _ = io.Copy(base.Err(), &b.errBuf)
+}
+
+// jobLabel names the i'th pull job for error reporting; platform jobs come
+// first, followed by the source-by-digest job, if any.
+func jobLabel(platforms []string, sourceDesc SourceDescriptor, i int) string {
+	if i < len(platforms) {
+		return platformLabel(platforms[i])
+	}
+	return "source (" + sourceDesc.Digest.String() + ")"
+}
+
+func platformLabel(platform string) string {
+	if platform == "" {
+		return "default platform"
+	}
+	return platform
+}
+
+// pullOnePlatform performs the trusted or privileged pull for a single platform value.
+func pullOnePlatform(ctx context.Context, cli command.Cli, imgRefAndAuth trust.ImageRefAndAuth, opts PullOptions, isCanonical bool, platform string, pullSource bool) error {
+	var err error
 	if !opts.untrusted && !isCanonical {
-		err = trustedPull(ctx, cli, imgRefAndAuth, opts.platform, pullSource)
+		err = trustedPull(ctx, cli, imgRefAndAuth, platform, pullSource)
 	} else {
-		err = imagePullPrivileged(ctx, cli, imgRefAndAuth, opts.all, opts.platform, pullSource)
+		err = imagePullPrivileged(ctx, cli, imgRefAndAuth, opts.all, platform, pullSource)
 	}
 	if err != nil {
 		if strings.Contains(err.Error(), "when fetching 'plugin'") {
@@ -100,3 +253,144 @@ func RunPull(cli command.Cli, opts PullOptions) error {
 	}
 	return nil
 }
+
+// pullSourceDescriptor pulls the source container identified by desc, which
+// was resolved by digest rather than by platform. It re-resolves trust and
+// registry auth against the more specific canonical (name@digest) reference.
+func pullSourceDescriptor(ctx context.Context, cli command.Cli, distributionRef reference.Named, desc SourceDescriptor, opts PullOptions) error {
+	canonical, err := reference.WithDigest(reference.TrimNamed(distributionRef), desc.Digest)
+	if err != nil {
+		return errors.Wrap(err, "resolving source container reference")
+	}
+
+	imgRefAndAuth, err := trust.GetImageReferencesAndAuth(ctx, nil, AuthResolver(cli), canonical.String())
+	if err != nil {
+		return err
+	}
+
+	return pullOnePlatform(ctx, cli, imgRefAndAuth, opts, true, "", false)
+}
+
+// defaultManifestLister is the ManifestLister ResolveSourceDescriptor uses
+// when pulling: it lists the manifests of imgRefAndAuth's repository through
+// the same registry client used for manifest inspection elsewhere in the CLI.
+type defaultManifestLister struct {
+	cli command.Cli
+}
+
+func (l defaultManifestLister) ListManifests(ctx context.Context, imgRefAndAuth trust.ImageRefAndAuth) ([]distribution.Descriptor, error) {
+	regClient := registryclient.NewRegistryClient(l.cli.ConfigFile(), command.UserAgent(), true)
+	manifests, err := regClient.GetManifestList(ctx, imgRefAndAuth.Reference())
+	if err != nil {
+		if isNotManifestListError(err) {
+			// Not every image is published as a manifest list/index; treat
+			// this as "no source descriptor found" rather than a hard
+			// failure. Anything else (auth, network, registry errors) is
+			// a real failure and must be reported, not swallowed.
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "fetching manifest list")
+	}
+
+	descriptors := make([]distribution.Descriptor, 0, len(manifests))
+	for _, m := range manifests {
+		descriptors = append(descriptors, m.Descriptor)
+	}
+	return descriptors, nil
+}
+
+// isNotManifestListError reports whether err indicates that the reference
+// simply isn't a manifest list/index, as opposed to an auth, network, or
+// registry failure fetching it.
+func isNotManifestListError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"not a manifest list", "not a manifest index", "unsupported manifest media type", "unsupported mediatype"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// digestEntry is one reference recorded to the --output-digests lockfile.
+type digestEntry struct {
+	Kind   string `json:"kind"`          // "image" or "source"
+	Tag    string `json:"tag,omitempty"` // tag the digest was resolved from, for --all-tags
+	Digest string `json:"digest"`        // fully-qualified name@sha256:... reference
+}
+
+// writeDigestLockfile inspects the images left behind by the pull(s) above and
+// records their fully-qualified digests to opts.outputDigests, so the caller
+// has a reproducible pin without having to shell out to `docker inspect`.
+func writeDigestLockfile(ctx context.Context, cli command.Cli, ref reference.Named, opts PullOptions, platforms []string, sourceDesc SourceDescriptor) error {
+	if opts.outputDigests == "" {
+		return nil
+	}
+
+	var entries []digestEntry
+
+	if sourceDesc.Digest != "" {
+		// Resolved by ResolveSourceDescriptor and pulled by pullSourceDescriptor
+		// as a standalone name@digest reference, so it's never tagged as
+		// ref and can't be rediscovered through ImageList below.
+		entries = append(entries, digestEntry{
+			Kind:   "source",
+			Digest: reference.FamiliarName(ref) + "@" + sourceDesc.Digest.String(),
+		})
+	}
+
+	if len(platforms) > 0 {
+		pulledSourceOnly := false
+		for _, platform := range platforms {
+			if platform == "linux/source" {
+				pulledSourceOnly = true
+			}
+		}
+
+		// Only the exact tag/digest reference actually pulled needs to be
+		// reflected here, except for --all-tags, which intentionally
+		// records every tag; the source container, if any, was already
+		// recorded above from sourceDesc.
+		filterRef := reference.FamiliarName(ref)
+		if !opts.all {
+			filterRef = reference.FamiliarString(ref)
+		}
+		summaries, err := cli.Client().ImageList(ctx, types.ImageListOptions{
+			Filters: filters.NewArgs(filters.Arg("reference", filterRef)),
+		})
+		if err != nil {
+			return errors.Wrap(err, "resolving digests for --output-digests")
+		}
+
+		for _, summary := range summaries {
+			kind := "image"
+			if pulledSourceOnly {
+				kind = "source"
+			}
+			if !opts.all {
+				for _, digest := range summary.RepoDigests {
+					entries = append(entries, digestEntry{Kind: kind, Digest: digest})
+				}
+				continue
+			}
+			// RepoTags and RepoDigests are independent: a digest shared by
+			// several tags has one entry in RepoDigests but one per tag in
+			// RepoTags, so pair every tag with every digest instead of
+			// zipping the two slices by index.
+			for _, tag := range summary.RepoTags {
+				for _, digest := range summary.RepoDigests {
+					entries = append(entries, digestEntry{Kind: kind, Tag: tag, Digest: digest})
+				}
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding --output-digests")
+	}
+	if err := ioutil.WriteFile(opts.outputDigests, data, 0o644); err != nil {
+		return errors.Wrap(err, "writing --output-digests")
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/cli/internal/test"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+func readDigestEntries(t *testing.T, path string) []digestEntry {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	var entries []digestEntry
+	assert.NilError(t, json.Unmarshal(data, &entries))
+	return entries
+}
+
+func TestWriteDigestLockfileSingleTag(t *testing.T) {
+	ref, err := reference.ParseNormalizedNamed("example.com/repo:tag")
+	assert.NilError(t, err)
+	ref = reference.TagNameOnly(ref)
+
+	cli := test.NewFakeCli(&test.FakeClient{
+		ImageListFunc: func(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error) {
+			return []types.ImageSummary{
+				{RepoDigests: []string{"example.com/repo@sha256:aaa"}, RepoTags: []string{"example.com/repo:tag"}},
+			}, nil
+		},
+	})
+
+	out := filepath.Join(t.TempDir(), "digests.json")
+	opts := PullOptions{outputDigests: out}
+
+	assert.NilError(t, writeDigestLockfile(context.Background(), cli, ref, opts, []string{""}, SourceDescriptor{}))
+
+	entries := readDigestEntries(t, out)
+	assert.Check(t, is.Len(entries, 1))
+	assert.Check(t, is.Equal(entries[0].Kind, "image"))
+	assert.Check(t, is.Equal(entries[0].Digest, "example.com/repo@sha256:aaa"))
+	assert.Check(t, is.Equal(entries[0].Tag, ""))
+}
+
+func TestWriteDigestLockfileAllTags(t *testing.T) {
+	ref, err := reference.ParseNormalizedNamed("example.com/repo")
+	assert.NilError(t, err)
+
+	cli := test.NewFakeCli(&test.FakeClient{
+		ImageListFunc: func(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error) {
+			return []types.ImageSummary{
+				{
+					RepoDigests: []string{"example.com/repo@sha256:aaa", "example.com/repo@sha256:bbb"},
+					RepoTags:    []string{"example.com/repo:v1", "example.com/repo:v2"},
+				},
+			}, nil
+		},
+	})
+
+	out := filepath.Join(t.TempDir(), "digests.json")
+	opts := PullOptions{all: true, outputDigests: out}
+
+	assert.NilError(t, writeDigestLockfile(context.Background(), cli, ref, opts, []string{""}, SourceDescriptor{}))
+
+	// RepoTags and RepoDigests are independent slices: a digest shared by
+	// several tags has one entry in RepoDigests but one per tag in
+	// RepoTags, so every tag is paired with every digest rather than
+	// zipping the two slices by index (which would silently drop tags
+	// whenever the slice lengths diverge).
+	entries := readDigestEntries(t, out)
+	assert.Check(t, is.Len(entries, 4))
+	assert.Check(t, is.Equal(entries[0].Tag, "example.com/repo:v1"))
+	assert.Check(t, is.Equal(entries[0].Digest, "example.com/repo@sha256:aaa"))
+	assert.Check(t, is.Equal(entries[1].Tag, "example.com/repo:v1"))
+	assert.Check(t, is.Equal(entries[1].Digest, "example.com/repo@sha256:bbb"))
+	assert.Check(t, is.Equal(entries[2].Tag, "example.com/repo:v2"))
+	assert.Check(t, is.Equal(entries[2].Digest, "example.com/repo@sha256:aaa"))
+	assert.Check(t, is.Equal(entries[3].Tag, "example.com/repo:v2"))
+	assert.Check(t, is.Equal(entries[3].Digest, "example.com/repo@sha256:bbb"))
+}
+
+// A source container resolved via ResolveSourceDescriptor is pulled as a
+// standalone name@digest reference, never tagged as ref, so it must be
+// recorded straight from sourceDesc rather than rediscovered through
+// ImageList.
+func TestWriteDigestLockfileSourceDigestSkipsImageList(t *testing.T) {
+	ref, err := reference.ParseNormalizedNamed("example.com/repo:tag")
+	assert.NilError(t, err)
+	ref = reference.TagNameOnly(ref)
+
+	called := false
+	cli := test.NewFakeCli(&test.FakeClient{
+		ImageListFunc: func(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error) {
+			called = true
+			return nil, nil
+		},
+	})
+
+	out := filepath.Join(t.TempDir(), "digests.json")
+	opts := PullOptions{source_only: true, outputDigests: out}
+
+	err = writeDigestLockfile(context.Background(), cli, ref, opts, nil, SourceDescriptor{Digest: "sha256:ccc"})
+	assert.NilError(t, err)
+	assert.Check(t, !called)
+
+	entries := readDigestEntries(t, out)
+	assert.Check(t, is.Len(entries, 1))
+	assert.Check(t, is.Equal(entries[0].Kind, "source"))
+	assert.Check(t, is.Equal(entries[0].Digest, "example.com/repo@sha256:ccc"))
+}
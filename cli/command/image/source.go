@@ -0,0 +1,87 @@
+package image
+
+import (
+	"context"
+	"strings"
+
+	"github.com/docker/cli/cli/trust"
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// DefaultSourceAnnotation is the OCI annotation `ResolveSourceDescriptor`
+// looks for on a sibling manifest when `--source-annotation` isn't given.
+const DefaultSourceAnnotation = "org.opencontainers.image.source.artifact"
+
+// SourceDescriptor identifies the manifest descriptor for an image's source
+// container, and how it was located.
+type SourceDescriptor struct {
+	Digest digest.Digest
+	// FromAnnotation is the annotation key that pointed at Digest. Both are
+	// "" when no descriptor carried the annotation.
+	FromAnnotation string
+}
+
+// ParseSourceAnnotation splits a `--source-annotation KEY=VALUE` flag value.
+// An empty raw string selects DefaultSourceAnnotation with no value
+// constraint, matching any descriptor that carries the key.
+func ParseSourceAnnotation(raw string) (key, value string, err error) {
+	if raw == "" {
+		return DefaultSourceAnnotation, "", nil
+	}
+	key, value, ok := strings.Cut(raw, "=")
+	if !ok {
+		return "", "", errors.Errorf("invalid --source-annotation %q, expected KEY=VALUE", raw)
+	}
+	return key, value, nil
+}
+
+// FindSourceDescriptor scans the descriptors of a manifest list/index for a
+// sibling carrying the annotation identified by key (and, if value is
+// non-empty, matching that exact value). It reports ok=false when no
+// descriptor qualifies, so callers can fall back to other means of locating
+// the source container.
+func FindSourceDescriptor(descriptors []distribution.Descriptor, key, value string) (desc distribution.Descriptor, ok bool) {
+	for _, d := range descriptors {
+		if annotation, present := d.Annotations[key]; present && (value == "" || annotation == value) {
+			return d, true
+		}
+	}
+	return distribution.Descriptor{}, false
+}
+
+// ManifestLister fetches the descriptors of a manifest list/index for the
+// repository and reference described by imgRefAndAuth. trustedPull and
+// imagePullPrivileged already build a client capable of this; ResolveSourceDescriptor
+// takes it as an argument instead of constructing its own so push and
+// inspect can supply their own.
+type ManifestLister interface {
+	ListManifests(ctx context.Context, imgRefAndAuth trust.ImageRefAndAuth) ([]distribution.Descriptor, error)
+}
+
+// ResolveSourceDescriptor locates the source container for an image by
+// looking for a sibling manifest descriptor carrying the annotation named by
+// annotationKV (a "--source-annotation KEY=VALUE" value, or "" for
+// DefaultSourceAnnotation). When no descriptor carries the annotation —
+// e.g. against a registry that hasn't been updated to publish it yet — it
+// returns a zero SourceDescriptor (empty Digest) and no error; it does not
+// resolve the synthetic "linux/source" platform itself, that fallback is
+// left to the caller.
+func ResolveSourceDescriptor(ctx context.Context, lister ManifestLister, imgRefAndAuth trust.ImageRefAndAuth, annotationKV string) (SourceDescriptor, error) {
+	key, value, err := ParseSourceAnnotation(annotationKV)
+	if err != nil {
+		return SourceDescriptor{}, err
+	}
+
+	descriptors, err := lister.ListManifests(ctx, imgRefAndAuth)
+	if err != nil {
+		return SourceDescriptor{}, errors.Wrap(err, "resolving source container")
+	}
+
+	if desc, ok := FindSourceDescriptor(descriptors, key, value); ok {
+		return SourceDescriptor{Digest: desc.Digest, FromAnnotation: key}, nil
+	}
+
+	return SourceDescriptor{}, nil
+}
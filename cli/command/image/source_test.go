@@ -0,0 +1,82 @@
+package image
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/cli/cli/trust"
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+type fakeManifestLister struct {
+	descriptors []distribution.Descriptor
+	err         error
+}
+
+func (l fakeManifestLister) ListManifests(ctx context.Context, imgRefAndAuth trust.ImageRefAndAuth) ([]distribution.Descriptor, error) {
+	return l.descriptors, l.err
+}
+
+func TestParseSourceAnnotation(t *testing.T) {
+	key, value, err := ParseSourceAnnotation("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(key, DefaultSourceAnnotation))
+	assert.Check(t, is.Equal(value, ""))
+
+	key, value, err = ParseSourceAnnotation("com.example.source=artifact")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(key, "com.example.source"))
+	assert.Check(t, is.Equal(value, "artifact"))
+
+	_, _, err = ParseSourceAnnotation("not-a-key-value-pair")
+	assert.ErrorContains(t, err, "invalid --source-annotation")
+}
+
+func TestFindSourceDescriptor(t *testing.T) {
+	sourceDigest := digest.Digest("sha256:abc")
+	descriptors := []distribution.Descriptor{
+		{Digest: "sha256:other", Annotations: map[string]string{"unrelated": "true"}},
+		{Digest: sourceDigest, Annotations: map[string]string{DefaultSourceAnnotation: "artifact"}},
+	}
+
+	desc, ok := FindSourceDescriptor(descriptors, DefaultSourceAnnotation, "")
+	assert.Check(t, ok)
+	assert.Check(t, is.Equal(desc.Digest, sourceDigest))
+
+	desc, ok = FindSourceDescriptor(descriptors, DefaultSourceAnnotation, "nope")
+	assert.Check(t, !ok)
+	assert.Check(t, is.Equal(desc.Digest, digest.Digest("")))
+
+	_, ok = FindSourceDescriptor(descriptors, "org.example.missing", "")
+	assert.Check(t, !ok)
+}
+
+func TestResolveSourceDescriptorFound(t *testing.T) {
+	sourceDigest := digest.Digest("sha256:abc")
+	lister := fakeManifestLister{descriptors: []distribution.Descriptor{
+		{Digest: sourceDigest, Annotations: map[string]string{DefaultSourceAnnotation: "artifact"}},
+	}}
+
+	desc, err := ResolveSourceDescriptor(context.Background(), lister, trust.ImageRefAndAuth{}, "")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(desc.Digest, sourceDigest))
+	assert.Check(t, is.Equal(desc.FromAnnotation, DefaultSourceAnnotation))
+}
+
+func TestResolveSourceDescriptorNotFound(t *testing.T) {
+	lister := fakeManifestLister{descriptors: []distribution.Descriptor{
+		{Digest: "sha256:unrelated"},
+	}}
+
+	desc, err := ResolveSourceDescriptor(context.Background(), lister, trust.ImageRefAndAuth{}, "")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(desc.Digest, digest.Digest("")))
+}
+
+func TestResolveSourceDescriptorInvalidAnnotation(t *testing.T) {
+	_, err := ResolveSourceDescriptor(context.Background(), fakeManifestLister{}, trust.ImageRefAndAuth{}, "missing-equals")
+	assert.ErrorContains(t, err, "invalid --source-annotation")
+}